@@ -0,0 +1,288 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/spf13/cobra"
+
+	"github.com/portalgun-io/docket/pkg/pki"
+)
+
+// certApplyConfig is the top-level shape of a `cert apply -f` config file.
+type certApplyConfig struct {
+	Issuers []certApplyIssuer `yaml:"issuers"`
+	Certs   []certApplySpec   `yaml:"certs"`
+}
+
+// certApplyIssuer names a configured CA that certs.yaml entries can refer
+// to by the `issuer` field.
+type certApplyIssuer struct {
+	Name string `yaml:"name"`
+	Crt  string `yaml:"crt"`
+	Key  string `yaml:"key"`
+}
+
+// certApplySpec is a single certificate entry in a certs.yaml config file.
+type certApplySpec struct {
+	Name         string   `yaml:"name"`
+	CommonName   string   `yaml:"commonName"`
+	Organization string   `yaml:"organization"`
+	DNSNames     []string `yaml:"dnsNames"`
+	IPAddresses  []string `yaml:"ipAddresses"`
+	Duration     string   `yaml:"duration"`
+	RenewBefore  string   `yaml:"renewBefore"`
+	KeyAlgo      string   `yaml:"keyAlgo"`
+	KeySize      int      `yaml:"keySize"`
+	EcdsaCurve   string   `yaml:"ecdsaCurve"`
+	IsCA         bool     `yaml:"isCA"`
+	KeyUsages    []string `yaml:"keyUsages"`
+	ExtKeyUsages []string `yaml:"extKeyUsages"`
+	OutDir       string   `yaml:"outDir"`
+	OutCert      string   `yaml:"outCert"`
+	OutKey       string   `yaml:"outKey"`
+	Issuer       string   `yaml:"issuer"`
+}
+
+type certApplyOptions struct {
+	File string
+}
+
+var certApplyOpt *certApplyOptions
+
+var certApplyCmd = &cobra.Command{
+	Use:     "apply",
+	Short:   "Issue or renew every certificate described in a config file.",
+	Example: "  surreal cert apply -f certs.yaml",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(certApplyOpt.File) == 0 {
+			return fmt.Errorf("Please provide a config file path.")
+		}
+
+		return nil
+
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		cfg, err := loadCertApplyConfig(certApplyOpt.File)
+		if err != nil {
+			return err
+		}
+
+		issuers, err := loadCertApplyIssuers(cfg.Issuers)
+		if err != nil {
+			return err
+		}
+
+		for _, spec := range cfg.Certs {
+			if err := applyCertSpec(spec, issuers); err != nil {
+				return fmt.Errorf("Could not apply cert %v: %v", spec.Name, err)
+			}
+		}
+
+		return nil
+
+	},
+}
+
+func init() {
+
+	certApplyOpt = &certApplyOptions{}
+
+	certApplyCmd.PersistentFlags().StringVarP(&certApplyOpt.File, "file", "f", "certs.yaml", "The path to the certificate config file.")
+
+}
+
+// loadCertApplyConfig reads and parses the config file at path.
+func loadCertApplyConfig(path string) (*certApplyConfig, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read file: %#v", path)
+	}
+
+	cfg := &certApplyConfig{}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("Could not parse config file %v: %#v", path, err)
+	}
+
+	return cfg, nil
+
+}
+
+// loadCertApplyIssuers loads every configured CA into a map keyed by name.
+func loadCertApplyIssuers(configs []certApplyIssuer) (map[string]pki.Issuer, error) {
+
+	issuers := make(map[string]pki.Issuer, len(configs))
+
+	for _, ic := range configs {
+
+		issuer, err := pki.LoadIssuer(ic.Name, ic.Crt, ic.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load issuer %v: %v", ic.Name, err)
+		}
+
+		issuers[ic.Name] = issuer
+
+	}
+
+	return issuers, nil
+
+}
+
+// applyCertSpec issues or renews the single certificate described by spec,
+// skipping it if an up-to-date certificate already exists on disk.
+func applyCertSpec(spec certApplySpec, issuers map[string]pki.Issuer) error {
+
+	issuer, ok := issuers[spec.Issuer]
+	if !ok {
+		return fmt.Errorf("Unknown issuer: %v", spec.Issuer)
+	}
+
+	pkiSpec, renewBefore, err := certApplySpecToPKI(spec)
+	if err != nil {
+		return err
+	}
+
+	crtPath, keyPath := certApplyOutPaths(spec)
+
+	renew, err := pki.NeedsRenewal(crtPath, renewBefore)
+	if err != nil {
+		return err
+	}
+
+	if !renew {
+		log.Printf("Certificate %v is up to date, skipping...", spec.Name)
+		return nil
+	}
+
+	key, crt, err := pki.Issue(pkiSpec, issuer)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Issuing certificate %v into %v...", spec.Name, crtPath)
+
+	return pki.WriteKeyPair(crtPath, keyPath, key, crt)
+
+}
+
+// certApplyOutPaths resolves the certificate and key output paths for spec,
+// defaulting to <outDir>/<name>.crt and <outDir>/<name>.key.
+func certApplyOutPaths(spec certApplySpec) (crtPath, keyPath string) {
+
+	crtName := spec.OutCert
+	if len(crtName) == 0 {
+		crtName = spec.Name + ".crt"
+	}
+
+	keyName := spec.OutKey
+	if len(keyName) == 0 {
+		keyName = spec.Name + ".key"
+	}
+
+	return filepath.Join(spec.OutDir, crtName), filepath.Join(spec.OutDir, keyName)
+
+}
+
+// certApplySpecToPKI converts the YAML-friendly certApplySpec into a
+// pki.Spec, along with the renewal window to check it against.
+func certApplySpecToPKI(spec certApplySpec) (pki.Spec, time.Duration, error) {
+
+	duration := 10 * 365 * 24 * time.Hour
+	if len(spec.Duration) > 0 {
+		d, err := time.ParseDuration(spec.Duration)
+		if err != nil {
+			return pki.Spec{}, 0, fmt.Errorf("Could not parse duration: %#v", err)
+		}
+		duration = d
+	}
+
+	renewBefore := 30 * 24 * time.Hour
+	if len(spec.RenewBefore) > 0 {
+		d, err := time.ParseDuration(spec.RenewBefore)
+		if err != nil {
+			return pki.Spec{}, 0, fmt.Errorf("Could not parse renewBefore: %#v", err)
+		}
+		renewBefore = d
+	}
+
+	keyAlgo := spec.KeyAlgo
+	if len(keyAlgo) == 0 {
+		keyAlgo = "rsa"
+	}
+
+	keySize := spec.KeySize
+	if keySize == 0 {
+		keySize = 4096
+	}
+
+	var ips []net.IP
+	for _, ip := range spec.IPAddresses {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return pki.Spec{}, 0, fmt.Errorf("Invalid IP address: %v", ip)
+		}
+		ips = append(ips, parsed)
+	}
+
+	keyUsages := spec.KeyUsages
+	if len(keyUsages) == 0 {
+		keyUsages = []string{"digitalSignature", "keyEncipherment"}
+	}
+
+	keyUsage, err := pki.ParseKeyUsageList(keyUsages)
+	if err != nil {
+		return pki.Spec{}, 0, err
+	}
+
+	extKeyUsages := spec.ExtKeyUsages
+	if len(extKeyUsages) == 0 {
+		extKeyUsages = []string{"serverAuth", "clientAuth"}
+	}
+
+	extKeyUsage, err := pki.ParseExtKeyUsageList(extKeyUsages)
+	if err != nil {
+		return pki.Spec{}, 0, err
+	}
+
+	return pki.Spec{
+		Name:         spec.Name,
+		CommonName:   spec.CommonName,
+		Organization: spec.Organization,
+		DNSNames:     spec.DNSNames,
+		IPAddresses:  ips,
+		Duration:     duration,
+		RenewBefore:  renewBefore,
+		KeyAlgo:      keyAlgo,
+		KeySize:      keySize,
+		EcdsaCurve:   spec.EcdsaCurve,
+		IsCA:         spec.IsCA,
+		KeyUsage:     keyUsage,
+		ExtKeyUsage:  extKeyUsage,
+		Issuer:       spec.Issuer,
+	}, renewBefore, nil
+
+}
@@ -17,32 +17,28 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"math/big"
-	"net"
+	"strings"
 	"time"
 
-	"io/ioutil"
-
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 
 	"github.com/spf13/cobra"
+
+	"github.com/portalgun-io/docket/pkg/pki"
 )
 
 type certClusterOptions struct {
-	CA struct {
-		Crt string
-		Key string
-	}
+	CA  caSignerOptions
 	Out struct {
-		Bit int
-		Com string
-		Org string
-		Crt string
-		Key string
+		Bit     int
+		Com     string
+		Org     string
+		Crt     string
+		Key     string
+		Algo    string
+		Curve   string
+		P12     string
+		P12Pass string
 	}
 }
 
@@ -54,12 +50,8 @@ var certClusterCmd = &cobra.Command{
 	Example: "  surreal cert cluster --ca-crt crt/ca.crt --ca-key crt/ca.key --out-crt crt/cluster.crt --out-key crt/cluster.key",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 
-		if len(certClusterOpt.CA.Crt) == 0 {
-			return fmt.Errorf("Please provide a CA certificate file path.")
-		}
-
-		if len(certClusterOpt.CA.Key) == 0 {
-			return fmt.Errorf("Please provide a CA private key file path.")
+		if err := validateCASigner(certClusterOpt.CA); err != nil {
+			return err
 		}
 
 		if len(certClusterOpt.Out.Org) == 0 {
@@ -74,63 +66,33 @@ var certClusterCmd = &cobra.Command{
 			return fmt.Errorf("Please provide a private key file path.")
 		}
 
+		switch strings.ToLower(certClusterOpt.Out.Algo) {
+		case "rsa", "ecdsa", "ed25519":
+		default:
+			return fmt.Errorf("Please provide a valid key algorithm (rsa, ecdsa, ed25519).")
+		}
+
 		return nil
 
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		var enc []byte
-
-		var dns []string
-		var ips []net.IP
+		dns, ips := pki.SplitHosts(args)
 
-		for _, v := range args {
-			chk := net.ParseIP(v)
-			switch {
-			case chk.To4() != nil:
-				ips = append(ips, chk.To4())
-			case chk.To16() != nil:
-				ips = append(ips, chk.To16())
-			default:
-				dns = append(dns, v)
-			}
-		}
-
-		caCrtFile, err := ioutil.ReadFile(certClusterOpt.CA.Crt)
-		if err != nil {
-			return fmt.Errorf("Could not read file: %#v", certClusterOpt.CA.Crt)
-		}
-
-		caCrtData, _ := pem.Decode(caCrtFile)
-
-		caCrt, err := x509.ParseCertificate(caCrtData.Bytes)
+		caCrt, caKey, err := resolveCASigner(certClusterOpt.CA)
 		if err != nil {
-			return fmt.Errorf("Could not parse CA certificate: %#v", err)
+			return err
 		}
 
-		caKeyFile, err := ioutil.ReadFile(certClusterOpt.CA.Key)
-		if err != nil {
-			return fmt.Errorf("Could not read file: %#v", certClusterOpt.CA.Crt)
-		}
-
-		caKeyData, _ := pem.Decode(caKeyFile)
-
-		caKey, err := x509.ParsePKCS1PrivateKey(caKeyData.Bytes)
-		if err != nil {
-			return fmt.Errorf("Could not parse CA private key: %#v", err)
-		}
-
-		csr := &x509.Certificate{
-			Subject: pkix.Name{
-				CommonName:   certClusterOpt.Out.Com,
-				Organization: []string{certClusterOpt.Out.Org},
-			},
-			BasicConstraintsValid: true,
-			SignatureAlgorithm:    x509.SHA512WithRSA,
-			PublicKeyAlgorithm:    x509.ECDSA,
-			NotBefore:             time.Now(),
-			NotAfter:              time.Now().AddDate(10, 0, 0),
-			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		spec := pki.Spec{
+			CommonName:   certClusterOpt.Out.Com,
+			Organization: certClusterOpt.Out.Org,
+			DNSNames:     dns,
+			IPAddresses:  ips,
+			Duration:     10 * 365 * 24 * time.Hour,
+			KeyAlgo:      certClusterOpt.Out.Algo,
+			KeySize:      certClusterOpt.Out.Bit,
+			EcdsaCurve:   certClusterOpt.Out.Curve,
 			KeyUsage: x509.KeyUsageCertSign |
 				x509.KeyUsageDigitalSignature |
 				x509.KeyUsageKeyAgreement |
@@ -138,40 +100,31 @@ var certClusterCmd = &cobra.Command{
 				x509.KeyUsageDataEncipherment |
 				x509.KeyUsageContentCommitment,
 			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-			DNSNames:    dns,
-			IPAddresses: ips,
 		}
 
-		key, err := rsa.GenerateKey(rand.Reader, certClusterOpt.Out.Bit)
+		key, crt, err := pki.Issue(spec, pki.Issuer{Crt: caCrt, Key: caKey})
 		if err != nil {
-			return fmt.Errorf("Certificate generation failed: %#v", err)
+			return err
 		}
 
-		prv := x509.MarshalPKCS1PrivateKey(key)
-
-		pub, err := x509.CreateCertificate(rand.Reader, csr, caCrt, &key.PublicKey, caKey)
-		if err != nil {
-			return fmt.Errorf("Certificate generation failed: %#v", err)
+		log.Printf("Saving server certificate file into %v...", certClusterOpt.Out.Crt)
+		log.Printf("Saving server private key file into %v...", certClusterOpt.Out.Key)
+		if err := pki.WriteKeyPair(certClusterOpt.Out.Crt, certClusterOpt.Out.Key, key, crt); err != nil {
+			return err
 		}
 
-		enc = pem.EncodeToMemory(&pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: pub,
-		})
+		if len(certClusterOpt.Out.P12) > 0 {
 
-		log.Printf("Saving server certificate file into %v...", certClusterOpt.Out.Crt)
-		if err := ioutil.WriteFile(certClusterOpt.Out.Crt, enc, 0644); err != nil {
-			return fmt.Errorf("Unable to write certificate file to %v: %#v", certClusterOpt.Out.Crt, err)
-		}
+			pass, err := resolveP12Password(certClusterOpt.Out.P12Pass)
+			if err != nil {
+				return fmt.Errorf("Could not read PKCS#12 password: %#v", err)
+			}
 
-		enc = pem.EncodeToMemory(&pem.Block{
-			Type:  "RSA PRIVATE KEY",
-			Bytes: prv,
-		})
+			log.Printf("Saving PKCS#12 bundle into %v...", certClusterOpt.Out.P12)
+			if err := writeP12Bundle(certClusterOpt.Out.P12, key, crt, caCrt, pass); err != nil {
+				return fmt.Errorf("Unable to write PKCS#12 bundle to %v: %#v", certClusterOpt.Out.P12, err)
+			}
 
-		log.Printf("Saving server private key file into %v...", certClusterOpt.Out.Key)
-		if err := ioutil.WriteFile(certClusterOpt.Out.Key, enc, 0644); err != nil {
-			return fmt.Errorf("Unable to write private key file to %v: %#v", certClusterOpt.Out.Key, err)
 		}
 
 		return nil
@@ -183,13 +136,16 @@ func init() {
 
 	certClusterOpt = &certClusterOptions{}
 
-	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.CA.Crt, "ca-crt", "ca.crt", "The path to the CA certificate file.")
-	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.CA.Key, "ca-key", "ca.key", "The path to the CA private key file.")
+	registerCAFlags(certClusterCmd.PersistentFlags(), &certClusterOpt.CA)
 
-	certClusterCmd.PersistentFlags().IntVar(&certClusterOpt.Out.Bit, "key-size", 4096, "The desired number of bits for the key.")
+	certClusterCmd.PersistentFlags().IntVar(&certClusterOpt.Out.Bit, "key-size", 4096, "The desired number of bits for the key (rsa only).")
+	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.Algo, "key-algo", "rsa", "The key algorithm to use (rsa, ecdsa, ed25519).")
+	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.Curve, "ecdsa-curve", "P256", "The elliptic curve to use for ecdsa keys (P224, P256, P384, P521).")
 	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.Com, "out-com", "", "The common name for the server certificate.")
 	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.Org, "out-org", "", "The origanisation name for the server certificate.")
 	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.Crt, "out-crt", "cluster.crt", "The path destination for the server certificate file.")
 	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.Key, "out-key", "cluster.key", "The path destination for the server private key file.")
+	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.P12, "out-p12", "", "The path destination for a PKCS#12 bundle of the certificate, key, and CA chain.")
+	certClusterCmd.PersistentFlags().StringVar(&certClusterOpt.Out.P12Pass, "out-p12-password", "", "The password to encrypt the PKCS#12 bundle with. Prompted for if --out-p12 is set and this is empty.")
 
 }
@@ -0,0 +1,139 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+
+	"github.com/spf13/cobra"
+
+	"github.com/portalgun-io/docket/pkg/pki"
+)
+
+type certCsrOptions struct {
+	Out struct {
+		Bit   int
+		Com   string
+		Org   string
+		Algo  string
+		Curve string
+		Csr   string
+		Key   string
+	}
+}
+
+var certCsrOpt *certCsrOptions
+
+var certCsrCmd = &cobra.Command{
+	Use:     "csr",
+	Short:   "Create a new private key and certificate signing request.",
+	Example: "  surreal cert csr --out-org Example --out-csr node.csr --out-key node.key",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(certCsrOpt.Out.Org) == 0 {
+			return fmt.Errorf("Please provide an organisation name.")
+		}
+
+		if len(certCsrOpt.Out.Csr) == 0 {
+			return fmt.Errorf("Please provide a certificate signing request file path.")
+		}
+
+		if len(certCsrOpt.Out.Key) == 0 {
+			return fmt.Errorf("Please provide a private key file path.")
+		}
+
+		switch strings.ToLower(certCsrOpt.Out.Algo) {
+		case "rsa", "ecdsa", "ed25519":
+		default:
+			return fmt.Errorf("Please provide a valid key algorithm (rsa, ecdsa, ed25519).")
+		}
+
+		return nil
+
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		dns, ips := pki.SplitHosts(args)
+
+		key, err := pki.GenerateKey(certCsrOpt.Out.Algo, certCsrOpt.Out.Curve, certCsrOpt.Out.Bit)
+		if err != nil {
+			return fmt.Errorf("Certificate signing request generation failed: %#v", err)
+		}
+
+		tpl := &x509.CertificateRequest{
+			Subject: pkix.Name{
+				CommonName:   certCsrOpt.Out.Com,
+				Organization: []string{certCsrOpt.Out.Org},
+			},
+			DNSNames:    dns,
+			IPAddresses: ips,
+		}
+
+		csr, err := x509.CreateCertificateRequest(rand.Reader, tpl, key)
+		if err != nil {
+			return fmt.Errorf("Certificate signing request generation failed: %#v", err)
+		}
+
+		enc := pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: csr,
+		})
+
+		log.Printf("Saving certificate signing request file into %v...", certCsrOpt.Out.Csr)
+		if err := ioutil.WriteFile(certCsrOpt.Out.Csr, enc, 0644); err != nil {
+			return fmt.Errorf("Unable to write certificate signing request file to %v: %#v", certCsrOpt.Out.Csr, err)
+		}
+
+		prv, prvType, err := pki.MarshalKey(key)
+		if err != nil {
+			return fmt.Errorf("Certificate signing request generation failed: %#v", err)
+		}
+
+		enc = pem.EncodeToMemory(&pem.Block{
+			Type:  prvType,
+			Bytes: prv,
+		})
+
+		log.Printf("Saving private key file into %v...", certCsrOpt.Out.Key)
+		if err := ioutil.WriteFile(certCsrOpt.Out.Key, enc, 0644); err != nil {
+			return fmt.Errorf("Unable to write private key file to %v: %#v", certCsrOpt.Out.Key, err)
+		}
+
+		return nil
+
+	},
+}
+
+func init() {
+
+	certCsrOpt = &certCsrOptions{}
+
+	certCsrCmd.PersistentFlags().IntVar(&certCsrOpt.Out.Bit, "key-size", 4096, "The desired number of bits for the key (rsa only).")
+	certCsrCmd.PersistentFlags().StringVar(&certCsrOpt.Out.Algo, "key-algo", "rsa", "The key algorithm to use (rsa, ecdsa, ed25519).")
+	certCsrCmd.PersistentFlags().StringVar(&certCsrOpt.Out.Curve, "ecdsa-curve", "P256", "The elliptic curve to use for ecdsa keys (P224, P256, P384, P521).")
+	certCsrCmd.PersistentFlags().StringVar(&certCsrOpt.Out.Com, "out-com", "", "The common name for the certificate signing request.")
+	certCsrCmd.PersistentFlags().StringVar(&certCsrOpt.Out.Org, "out-org", "", "The origanisation name for the certificate signing request.")
+	certCsrCmd.PersistentFlags().StringVar(&certCsrOpt.Out.Csr, "out-csr", "node.csr", "The path destination for the certificate signing request file.")
+	certCsrCmd.PersistentFlags().StringVar(&certCsrOpt.Out.Key, "out-key", "node.key", "The path destination for the private key file.")
+
+}
@@ -0,0 +1,167 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/spf13/pflag"
+
+	"github.com/portalgun-io/docket/pkg/pki"
+)
+
+// caPKCS11Options holds the flags needed to load a CA private key from a
+// PKCS#11 token instead of a PEM file.
+type caPKCS11Options struct {
+	Module     string
+	TokenLabel string
+	Pin        string
+	KeyLabel   string
+}
+
+// caSignerOptions holds every flag needed to resolve the crypto.Signer that
+// signs certificates for a `cert *` subcommand, whether the CA private key
+// lives in a PEM file on disk or inside a PKCS#11 token.
+type caSignerOptions struct {
+	Crt    string
+	Key    string
+	PKCS11 caPKCS11Options
+}
+
+// registerCAFlags registers the `--ca-*` flags shared by every `cert *`
+// subcommand that signs with a configured CA.
+func registerCAFlags(fs *pflag.FlagSet, ca *caSignerOptions) {
+
+	fs.StringVar(&ca.Crt, "ca-crt", "ca.crt", "The path to the CA certificate file.")
+	fs.StringVar(&ca.Key, "ca-key", "ca.key", "The path to the CA private key file.")
+
+	fs.StringVar(&ca.PKCS11.Module, "ca-pkcs11-module", "", "The path to a PKCS#11 module to load the CA private key from. Overrides --ca-key.")
+	fs.StringVar(&ca.PKCS11.TokenLabel, "ca-pkcs11-token-label", "", "The label of the PKCS#11 token holding the CA private key.")
+	fs.StringVar(&ca.PKCS11.Pin, "ca-pkcs11-pin", "", "The PIN for the PKCS#11 token. Prompted for if empty and a module is configured.")
+	fs.StringVar(&ca.PKCS11.KeyLabel, "ca-key-label", "", "The label of the CA private key on the PKCS#11 token.")
+
+}
+
+// validateCASigner checks that ca identifies at least one way to load the CA
+// private key, either a PEM file or a PKCS#11 token. When both are set, the
+// PKCS#11 token is used and the PEM file is ignored.
+func validateCASigner(ca caSignerOptions) error {
+
+	if len(ca.Crt) == 0 {
+		return fmt.Errorf("Please provide a CA certificate file path.")
+	}
+
+	if len(ca.PKCS11.Module) == 0 && len(ca.Key) == 0 {
+		return fmt.Errorf("Please provide a CA private key file path, or a --ca-pkcs11-module.")
+	}
+
+	if len(ca.PKCS11.Module) > 0 && len(ca.PKCS11.KeyLabel) == 0 {
+		return fmt.Errorf("Please provide a --ca-key-label to select the key on the PKCS#11 token.")
+	}
+
+	return nil
+
+}
+
+// resolveCASigner loads the CA certificate and a crypto.Signer for the CA
+// private key, using the PKCS#11 token configured by ca.PKCS11 if present,
+// falling back to the PEM file at ca.Key otherwise. It is shared by every
+// `cert *` subcommand that signs with a configured CA.
+func resolveCASigner(ca caSignerOptions) (*x509.Certificate, crypto.Signer, error) {
+
+	if len(ca.PKCS11.Module) > 0 {
+
+		caCrt, err := pki.LoadCertificate(ca.Crt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not load CA certificate: %v", err)
+		}
+
+		caKey, err := loadPKCS11Signer(ca.PKCS11)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return caCrt, caKey, nil
+
+	}
+
+	issuer, err := pki.LoadIssuer("", ca.Crt, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return issuer.Crt, issuer.Key, nil
+
+}
+
+// loadPKCS11Signer loads the CA private key identified by opts.KeyLabel from
+// the PKCS#11 token at opts.Module, prompting on stdin for the PIN if it was
+// not supplied.
+func loadPKCS11Signer(opts caPKCS11Options) (crypto.Signer, error) {
+
+	pin := opts.Pin
+	if len(pin) == 0 {
+		p, err := promptSecret("Enter PKCS#11 PIN: ")
+		if err != nil {
+			return nil, err
+		}
+		pin = p
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       opts.Module,
+		TokenLabel: opts.TokenLabel,
+		Pin:        pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not open PKCS#11 module %v: %#v", opts.Module, err)
+	}
+
+	signer, err := ctx.FindKeyPair(nil, []byte(opts.KeyLabel))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load PKCS#11 key %v: %#v", opts.KeyLabel, err)
+	}
+
+	if signer == nil {
+		return nil, fmt.Errorf("No PKCS#11 key found with label %v", opts.KeyLabel)
+	}
+
+	return signer, nil
+
+}
+
+// promptSecret prompts on stdin with terminal echo disabled, used for
+// passwords and PINs that should not be typed in the clear.
+func promptSecret(prompt string) (string, error) {
+
+	fmt.Fprint(os.Stderr, prompt)
+
+	secret, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+
+}
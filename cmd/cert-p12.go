@@ -0,0 +1,66 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// writeP12Bundle encodes the given leaf certificate, private key, and CA
+// certificate chain as a password-protected PKCS#12 archive and writes it
+// to path. It is shared by every `cert *` subcommand that emits a `.p12`
+// alongside its PEM output.
+func writeP12Bundle(path string, key crypto.Signer, crt, caCrt *x509.Certificate, password string) error {
+
+	data, err := pkcs12.Encode(rand.Reader, key, crt, []*x509.Certificate{caCrt}, password)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+
+}
+
+// writeP12TrustStore encodes the given certificates as a password-protected
+// PKCS#12 trust store and writes it to path. It is used where no private
+// key is available to bundle alongside the certificate, such as `cert
+// sign`, which only ever sees the externally-supplied CSR's public key.
+func writeP12TrustStore(path string, password string, crts ...*x509.Certificate) error {
+
+	data, err := pkcs12.EncodeTrustStore(rand.Reader, crts, password)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+
+}
+
+// resolveP12Password returns the given password, prompting on stdin with
+// terminal echo disabled if it is empty.
+func resolveP12Password(password string) (string, error) {
+
+	if len(password) > 0 {
+		return password, nil
+	}
+
+	return promptSecret("Enter PKCS#12 password: ")
+
+}
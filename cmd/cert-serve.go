@@ -0,0 +1,382 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/spf13/cobra"
+
+	"github.com/portalgun-io/docket/pkg/pki"
+)
+
+type certServeOptions struct {
+	File          string
+	CheckInterval time.Duration
+	ReloadCmd     string
+	PidFile       string
+	MetricsAddr   string
+}
+
+var certServeOpt *certServeOptions
+
+var certServeCmd = &cobra.Command{
+	Use:     "serve",
+	Short:   "Continuously issue and rotate every certificate described in a config file.",
+	Example: "  surreal cert serve --config certs.yaml --check-interval 1h",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(certServeOpt.File) == 0 {
+			return fmt.Errorf("Please provide a config file path.")
+		}
+
+		return nil
+
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		metrics := newCertServeMetrics()
+		state := newCertServeState()
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			if !state.healthy() {
+				http.Error(w, "a managed certificate is past its renewal deadline", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		})
+
+		go func() {
+			log.Printf("Serving /metrics and /healthz on %v...", certServeOpt.MetricsAddr)
+			if err := http.ListenAndServe(certServeOpt.MetricsAddr, mux); err != nil {
+				log.Printf("Metrics server stopped: %#v", err)
+			}
+		}()
+
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("Could not start config file watcher: %#v", err)
+		}
+		defer watcher.Close()
+
+		// Watch the containing directory rather than the file itself: most
+		// editors and config-management tools save by writing a tempfile and
+		// renaming it over the target, which replaces the inode and would
+		// silently stop a watch placed directly on the file.
+		configDir := filepath.Dir(certServeOpt.File)
+		configName := filepath.Base(certServeOpt.File)
+
+		if err := watcher.Add(configDir); err != nil {
+			return fmt.Errorf("Could not watch config directory %v: %#v", configDir, err)
+		}
+
+		reconcileCerts(certServeOpt.File, state, metrics)
+
+		ticker := time.NewTicker(certServeOpt.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+
+			case <-ticker.C:
+				reconcileCerts(certServeOpt.File, state, metrics)
+
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return nil
+				}
+				if filepath.Base(event.Name) != configName {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					log.Printf("Config file %v changed, reconciling...", certServeOpt.File)
+					reconcileCerts(certServeOpt.File, state, metrics)
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return nil
+				}
+				log.Printf("Config file watcher error: %#v", err)
+
+			}
+		}
+
+	},
+}
+
+func init() {
+
+	certServeOpt = &certServeOptions{}
+
+	certServeCmd.PersistentFlags().StringVar(&certServeOpt.File, "config", "certs.yaml", "The path to the certificate config file.")
+	certServeCmd.PersistentFlags().DurationVar(&certServeOpt.CheckInterval, "check-interval", time.Hour, "How often to check every certificate for renewal, independent of config file changes.")
+	certServeCmd.PersistentFlags().StringVar(&certServeOpt.ReloadCmd, "reload-cmd", "", "A command to run after a certificate is rotated, e.g. 'systemctl reload surreal'.")
+	certServeCmd.PersistentFlags().StringVar(&certServeOpt.PidFile, "pidfile", "", "A pidfile to send SIGHUP to after a certificate is rotated. Ignored if --reload-cmd is set.")
+	certServeCmd.PersistentFlags().StringVar(&certServeOpt.MetricsAddr, "metrics-addr", ":9119", "The address to serve /metrics and /healthz on.")
+
+}
+
+// certServeMetrics holds the Prometheus collectors reported on /metrics,
+// labelled by certificate name.
+type certServeMetrics struct {
+	notAfter        *prometheus.GaugeVec
+	lastRenewal     *prometheus.GaugeVec
+	renewalFailures *prometheus.CounterVec
+}
+
+func newCertServeMetrics() *certServeMetrics {
+
+	m := &certServeMetrics{
+		notAfter: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "not_after_seconds",
+			Help: "Unix timestamp the certificate's current validity period ends.",
+		}, []string{"cert"}),
+		lastRenewal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "last_renewal_timestamp",
+			Help: "Unix timestamp the certificate was last successfully issued.",
+		}, []string{"cert"}),
+		renewalFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "renewal_failures_total",
+			Help: "Total number of failed renewal attempts for the certificate.",
+		}, []string{"cert"}),
+	}
+
+	prometheus.MustRegister(m.notAfter, m.lastRenewal, m.renewalFailures)
+
+	return m
+
+}
+
+// certServeState tracks the expiry and renewal window of every certificate
+// managed by `cert serve`, so that /healthz can answer without touching
+// disk or the config file.
+type certServeState struct {
+	mu    sync.Mutex
+	certs map[string]certServeEntry
+}
+
+type certServeEntry struct {
+	notAfter    time.Time
+	renewBefore time.Duration
+	failing     bool
+}
+
+func newCertServeState() *certServeState {
+	return &certServeState{certs: make(map[string]certServeEntry)}
+}
+
+func (s *certServeState) update(name string, notAfter time.Time, renewBefore time.Duration) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.certs[name] = certServeEntry{notAfter: notAfter, renewBefore: renewBefore}
+
+}
+
+// fail marks name as failing reconciliation, so /healthz reports unhealthy
+// for it even if it has no expiry recorded yet, e.g. when a spec's issuer
+// reference is invalid and reconciliation never reaches pki.Issue.
+func (s *certServeState) fail(name string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.certs[name]
+	entry.failing = true
+	s.certs[name] = entry
+
+}
+
+// healthy reports whether every managed certificate is still within its
+// renewal window and not currently failing reconciliation.
+func (s *certServeState) healthy() bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	for _, entry := range s.certs {
+		if entry.failing {
+			return false
+		}
+		if now.After(entry.notAfter.Add(-entry.renewBefore)) {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// reconcileCerts loads the config file at path and issues or renews every
+// certificate it describes, updating state and metrics as it goes. Errors
+// for an individual certificate are logged and counted rather than aborting
+// the whole reconciliation pass.
+func reconcileCerts(path string, state *certServeState, metrics *certServeMetrics) {
+
+	cfg, err := loadCertApplyConfig(path)
+	if err != nil {
+		log.Printf("Could not load config file %v: %#v", path, err)
+		return
+	}
+
+	issuers, err := loadCertApplyIssuers(cfg.Issuers)
+	if err != nil {
+		log.Printf("Could not load issuers from config file %v: %#v", path, err)
+		return
+	}
+
+	for _, spec := range cfg.Certs {
+		if err := reconcileCertSpec(spec, issuers, state, metrics); err != nil {
+			log.Printf("Could not reconcile certificate %v: %#v", spec.Name, err)
+			metrics.renewalFailures.WithLabelValues(spec.Name).Inc()
+			state.fail(spec.Name)
+		}
+	}
+
+}
+
+// reconcileCertSpec issues or renews the single certificate described by
+// spec if it is missing or within its renewal window, triggering a reload
+// on rotation, and refreshes state/metrics either way.
+func reconcileCertSpec(spec certApplySpec, issuers map[string]pki.Issuer, state *certServeState, metrics *certServeMetrics) error {
+
+	issuer, ok := issuers[spec.Issuer]
+	if !ok {
+		return fmt.Errorf("Unknown issuer: %v", spec.Issuer)
+	}
+
+	pkiSpec, renewBefore, err := certApplySpecToPKI(spec)
+	if err != nil {
+		return err
+	}
+
+	crtPath, keyPath := certApplyOutPaths(spec)
+
+	renew, err := pki.NeedsRenewal(crtPath, renewBefore)
+	if err != nil {
+		return err
+	}
+
+	var crt *x509.Certificate
+
+	if renew {
+
+		key, issued, err := pki.Issue(pkiSpec, issuer)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Renewing certificate %v into %v...", spec.Name, crtPath)
+
+		if err := pki.WriteKeyPairAtomic(crtPath, keyPath, key, issued); err != nil {
+			return err
+		}
+
+		metrics.lastRenewal.WithLabelValues(spec.Name).Set(float64(time.Now().Unix()))
+
+		if err := triggerReload(); err != nil {
+			log.Printf("Could not trigger reload after renewing %v: %#v", spec.Name, err)
+		}
+
+		crt = issued
+
+	} else {
+
+		loaded, err := pki.LoadCertificate(crtPath)
+		if err != nil {
+			return err
+		}
+
+		crt = loaded
+
+	}
+
+	metrics.notAfter.WithLabelValues(spec.Name).Set(float64(crt.NotAfter.Unix()))
+	state.update(spec.Name, crt.NotAfter, renewBefore)
+
+	return nil
+
+}
+
+// triggerReload runs --reload-cmd if configured, falling back to sending
+// SIGHUP to the process named in --pidfile.
+func triggerReload() error {
+
+	if len(certServeOpt.ReloadCmd) > 0 {
+
+		log.Printf("Running reload command: %v", certServeOpt.ReloadCmd)
+
+		reload := exec.Command("sh", "-c", certServeOpt.ReloadCmd)
+		reload.Stdout = os.Stdout
+		reload.Stderr = os.Stderr
+
+		if err := reload.Run(); err != nil {
+			return fmt.Errorf("Reload command failed: %#v", err)
+		}
+
+		return nil
+
+	}
+
+	if len(certServeOpt.PidFile) == 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(certServeOpt.PidFile)
+	if err != nil {
+		return fmt.Errorf("Could not read pidfile: %#v", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("Could not parse pidfile %v: %#v", certServeOpt.PidFile, err)
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("Could not find process %v: %#v", pid, err)
+	}
+
+	log.Printf("Sending SIGHUP to pid %v...", pid)
+
+	if err := proc.Signal(syscall.SIGHUP); err != nil {
+		return fmt.Errorf("Could not signal process %v: %#v", pid, err)
+	}
+
+	return nil
+
+}
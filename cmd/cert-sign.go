@@ -0,0 +1,189 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/spf13/cobra"
+
+	"github.com/portalgun-io/docket/pkg/pki"
+)
+
+type certSignOptions struct {
+	CA caSignerOptions
+	In struct {
+		Csr string
+	}
+	Out struct {
+		Crt         string
+		NotBefore   string
+		Duration    time.Duration
+		IsCA        bool
+		KeyUsage    string
+		ExtKeyUsage string
+		P12         string
+		P12Pass     string
+	}
+}
+
+var certSignOpt *certSignOptions
+
+var certSignCmd = &cobra.Command{
+	Use:     "sign",
+	Short:   "Sign an externally-supplied certificate signing request.",
+	Example: "  surreal cert sign --ca-crt crt/ca.crt --ca-key crt/ca.key --in-csr node.csr --out-crt node.crt",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := validateCASigner(certSignOpt.CA); err != nil {
+			return err
+		}
+
+		if len(certSignOpt.In.Csr) == 0 {
+			return fmt.Errorf("Please provide a certificate signing request file path.")
+		}
+
+		if len(certSignOpt.Out.Crt) == 0 {
+			return fmt.Errorf("Please provide a certificate file path.")
+		}
+
+		return nil
+
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		caCrt, caKey, err := resolveCASigner(certSignOpt.CA)
+		if err != nil {
+			return err
+		}
+
+		csrFile, err := ioutil.ReadFile(certSignOpt.In.Csr)
+		if err != nil {
+			return fmt.Errorf("Could not read file: %#v", certSignOpt.In.Csr)
+		}
+
+		csrData, _ := pem.Decode(csrFile)
+		if csrData == nil {
+			return fmt.Errorf("Could not parse certificate signing request: no PEM data found in %v", certSignOpt.In.Csr)
+		}
+
+		csr, err := x509.ParseCertificateRequest(csrData.Bytes)
+		if err != nil {
+			return fmt.Errorf("Could not parse certificate signing request: %#v", err)
+		}
+
+		if err := csr.CheckSignature(); err != nil {
+			return fmt.Errorf("Certificate signing request signature is invalid: %#v", err)
+		}
+
+		notBefore := time.Now()
+		if len(certSignOpt.Out.NotBefore) > 0 {
+			notBefore, err = time.Parse(time.RFC3339, certSignOpt.Out.NotBefore)
+			if err != nil {
+				return fmt.Errorf("Could not parse --not-before: %#v", err)
+			}
+		}
+
+		keyUsage, err := pki.ParseKeyUsages(certSignOpt.Out.KeyUsage)
+		if err != nil {
+			return err
+		}
+
+		extKeyUsage, err := pki.ParseExtKeyUsages(certSignOpt.Out.ExtKeyUsage)
+		if err != nil {
+			return err
+		}
+
+		tpl := pki.NewTemplate(pki.TemplateSpec{
+			Subject:        csr.Subject,
+			DNSNames:       csr.DNSNames,
+			IPAddresses:    csr.IPAddresses,
+			EmailAddresses: csr.EmailAddresses,
+			URIs:           csr.URIs,
+			NotBefore:      notBefore,
+			NotAfter:       notBefore.Add(certSignOpt.Out.Duration),
+			IsCA:           certSignOpt.Out.IsCA,
+			KeyUsage:       keyUsage,
+			ExtKeyUsage:    extKeyUsage,
+		})
+		tpl.SignatureAlgorithm = pki.SignatureAlgorithmFor(caCrt.PublicKey)
+
+		pub, err := x509.CreateCertificate(rand.Reader, tpl, caCrt, csr.PublicKey, caKey)
+		if err != nil {
+			return fmt.Errorf("Certificate generation failed: %#v", err)
+		}
+
+		enc := pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: pub,
+		})
+
+		log.Printf("Saving signed certificate file into %v...", certSignOpt.Out.Crt)
+		if err := ioutil.WriteFile(certSignOpt.Out.Crt, enc, 0644); err != nil {
+			return fmt.Errorf("Unable to write certificate file to %v: %#v", certSignOpt.Out.Crt, err)
+		}
+
+		if len(certSignOpt.Out.P12) > 0 {
+
+			crt, err := x509.ParseCertificate(pub)
+			if err != nil {
+				return fmt.Errorf("Could not parse signed certificate: %#v", err)
+			}
+
+			pass, err := resolveP12Password(certSignOpt.Out.P12Pass)
+			if err != nil {
+				return fmt.Errorf("Could not read PKCS#12 password: %#v", err)
+			}
+
+			// cert sign never has access to the CSR requester's private key,
+			// so the signed certificate and CA chain are bundled as a PKCS#12
+			// trust store rather than a full cert+key+chain archive.
+			log.Printf("Saving PKCS#12 trust store into %v...", certSignOpt.Out.P12)
+			if err := writeP12TrustStore(certSignOpt.Out.P12, pass, crt, caCrt); err != nil {
+				return fmt.Errorf("Unable to write PKCS#12 trust store to %v: %#v", certSignOpt.Out.P12, err)
+			}
+
+		}
+
+		return nil
+
+	},
+}
+
+func init() {
+
+	certSignOpt = &certSignOptions{}
+
+	registerCAFlags(certSignCmd.PersistentFlags(), &certSignOpt.CA)
+
+	certSignCmd.PersistentFlags().StringVar(&certSignOpt.In.Csr, "in-csr", "", "The path to the PEM-encoded certificate signing request to sign.")
+
+	certSignCmd.PersistentFlags().StringVar(&certSignOpt.Out.Crt, "out-crt", "signed.crt", "The path destination for the signed certificate file.")
+	certSignCmd.PersistentFlags().StringVar(&certSignOpt.Out.NotBefore, "not-before", "", "The RFC3339 timestamp the certificate becomes valid at. Defaults to now.")
+	certSignCmd.PersistentFlags().DurationVar(&certSignOpt.Out.Duration, "duration", 10*365*24*time.Hour, "The length of time the certificate is valid for.")
+	certSignCmd.PersistentFlags().BoolVar(&certSignOpt.Out.IsCA, "is-ca", false, "Whether the signed certificate is allowed to sign other certificates.")
+	certSignCmd.PersistentFlags().StringVar(&certSignOpt.Out.KeyUsage, "key-usage", "digitalSignature,keyEncipherment", "A comma-separated list of key usages to grant the certificate.")
+	certSignCmd.PersistentFlags().StringVar(&certSignOpt.Out.ExtKeyUsage, "ext-key-usage", "serverAuth,clientAuth", "A comma-separated list of extended key usages to grant the certificate.")
+	certSignCmd.PersistentFlags().StringVar(&certSignOpt.Out.P12, "out-p12", "", "The path destination for a PKCS#12 trust store bundling the signed certificate and CA chain.")
+	certSignCmd.PersistentFlags().StringVar(&certSignOpt.Out.P12Pass, "out-p12-password", "", "The password to encrypt the PKCS#12 trust store with. Prompted for if --out-p12 is set and this is empty.")
+
+}
@@ -0,0 +1,300 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Issuer is a configured CA capable of signing certificates.
+type Issuer struct {
+	Name string
+	Crt  *x509.Certificate
+	Key  crypto.Signer
+}
+
+// Spec describes a single certificate to be issued, as found in a `cert
+// apply`/`cert serve` config file or built directly by a `cert *` command.
+type Spec struct {
+	Name         string
+	CommonName   string
+	Organization string
+	DNSNames     []string
+	IPAddresses  []net.IP
+	Duration     time.Duration
+	RenewBefore  time.Duration
+	KeyAlgo      string
+	KeySize      int
+	EcdsaCurve   string
+	IsCA         bool
+	KeyUsage     x509.KeyUsage
+	ExtKeyUsage  []x509.ExtKeyUsage
+	Issuer       string
+}
+
+// Issue generates a new private key for spec and signs a certificate for it
+// using issuer, returning the key and the signed certificate. It is the
+// single code path shared by `cert cluster`, `cert apply`, and `cert serve`.
+func Issue(spec Spec, issuer Issuer) (crypto.Signer, *x509.Certificate, error) {
+
+	key, err := GenerateKey(spec.KeyAlgo, spec.EcdsaCurve, spec.KeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Certificate generation failed: %#v", err)
+	}
+
+	now := time.Now()
+
+	tpl := NewTemplate(TemplateSpec{
+		Subject: pkix.Name{
+			CommonName:   spec.CommonName,
+			Organization: []string{spec.Organization},
+		},
+		DNSNames:    spec.DNSNames,
+		IPAddresses: spec.IPAddresses,
+		NotBefore:   now,
+		NotAfter:    now.Add(spec.Duration),
+		IsCA:        spec.IsCA,
+		KeyUsage:    spec.KeyUsage,
+		ExtKeyUsage: spec.ExtKeyUsage,
+	})
+	tpl.SignatureAlgorithm = SignatureAlgorithmFor(issuer.Crt.PublicKey)
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, issuer.Crt, key.Public(), issuer.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Certificate generation failed: %#v", err)
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not parse generated certificate: %#v", err)
+	}
+
+	return key, crt, nil
+
+}
+
+// NeedsRenewal reports whether the certificate stored at crtPath is missing,
+// unreadable, or within renewBefore of its expiry.
+func NeedsRenewal(crtPath string, renewBefore time.Duration) (bool, error) {
+
+	data, err := ioutil.ReadFile(crtPath)
+	if err != nil {
+		return true, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return true, nil
+	}
+
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true, nil
+	}
+
+	return !time.Now().Before(crt.NotAfter.Add(-renewBefore)), nil
+
+}
+
+// WriteKeyPair PEM-encodes key and crt and writes them to keyPath and
+// crtPath respectively.
+func WriteKeyPair(crtPath, keyPath string, key crypto.Signer, crt *x509.Certificate) error {
+
+	if err := ensureDir(crtPath); err != nil {
+		return err
+	}
+
+	if err := ensureDir(keyPath); err != nil {
+		return err
+	}
+
+	crtEnc := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: crt.Raw,
+	})
+
+	if err := ioutil.WriteFile(crtPath, crtEnc, 0644); err != nil {
+		return fmt.Errorf("Unable to write certificate file to %v: %#v", crtPath, err)
+	}
+
+	prv, prvType, err := MarshalKey(key)
+	if err != nil {
+		return err
+	}
+
+	keyEnc := pem.EncodeToMemory(&pem.Block{
+		Type:  prvType,
+		Bytes: prv,
+	})
+
+	if err := ioutil.WriteFile(keyPath, keyEnc, 0644); err != nil {
+		return fmt.Errorf("Unable to write private key file to %v: %#v", keyPath, err)
+	}
+
+	return nil
+
+}
+
+// WriteKeyPairAtomic PEM-encodes key and crt and writes them to keyPath and
+// crtPath, writing each to a tempfile in the destination directory first and
+// renaming it into place so that a reader can never observe a partial file.
+// It is used by `cert serve`, where a running process may be reading the
+// certificate at the moment it is rotated.
+func WriteKeyPairAtomic(crtPath, keyPath string, key crypto.Signer, crt *x509.Certificate) error {
+
+	crtEnc := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: crt.Raw,
+	})
+
+	if err := atomicWriteFile(crtPath, crtEnc, 0644); err != nil {
+		return fmt.Errorf("Unable to write certificate file to %v: %#v", crtPath, err)
+	}
+
+	prv, prvType, err := MarshalKey(key)
+	if err != nil {
+		return err
+	}
+
+	keyEnc := pem.EncodeToMemory(&pem.Block{
+		Type:  prvType,
+		Bytes: prv,
+	})
+
+	if err := atomicWriteFile(keyPath, keyEnc, 0644); err != nil {
+		return fmt.Errorf("Unable to write private key file to %v: %#v", keyPath, err)
+	}
+
+	return nil
+
+}
+
+// ensureDir creates the directory containing path if it does not already
+// exist, so that a fresh outDir can be written to on a first run.
+func ensureDir(path string) error {
+
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Could not create directory %v: %#v", dir, err)
+	}
+
+	return nil
+
+}
+
+// atomicWriteFile writes data to a tempfile alongside path and renames it
+// into place, so that a concurrent reader always sees either the old file
+// or the new one, never a partial write.
+func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
+
+	if err := ensureDir(path); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+
+}
+
+// LoadCertificate reads and parses the PEM-encoded certificate at path.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read file: %#v", path)
+	}
+
+	data, _ := pem.Decode(file)
+	if data == nil {
+		return nil, fmt.Errorf("Could not parse certificate: no PEM data found in %v", path)
+	}
+
+	crt, err := x509.ParseCertificate(data.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse certificate: %#v", err)
+	}
+
+	return crt, nil
+
+}
+
+// LoadSigner reads and parses the PEM-encoded private key at path.
+func LoadSigner(path string) (crypto.Signer, error) {
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read file: %#v", path)
+	}
+
+	data, _ := pem.Decode(file)
+	if data == nil {
+		return nil, fmt.Errorf("Could not parse private key: no PEM data found in %v", path)
+	}
+
+	key, err := ParseSigner(data.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse private key: %#v", err)
+	}
+
+	return key, nil
+
+}
+
+// LoadIssuer reads and parses the CA certificate and private key PEM files
+// at crtPath and keyPath into an Issuer named name.
+func LoadIssuer(name, crtPath, keyPath string) (Issuer, error) {
+
+	crt, err := LoadCertificate(crtPath)
+	if err != nil {
+		return Issuer{}, fmt.Errorf("Could not load CA certificate: %v", err)
+	}
+
+	key, err := LoadSigner(keyPath)
+	if err != nil {
+		return Issuer{}, fmt.Errorf("Could not load CA private key: %v", err)
+	}
+
+	return Issuer{Name: name, Crt: crt, Key: key}, nil
+
+}
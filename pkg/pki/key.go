@@ -0,0 +1,152 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pki generates and signs certificates for a SurrealDB cluster. It
+// is used by the `surreal cert *` commands and is kept independent of cobra
+// so it can be reused by long-running processes such as `cert serve`.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// GenerateKey creates a new private key using the requested algorithm,
+// returning a crypto.Signer usable for certificate issuance. curve is only
+// consulted when algo is "ecdsa", and bits only when algo is "rsa".
+func GenerateKey(algo, curve string, bits int) (crypto.Signer, error) {
+
+	switch strings.ToLower(algo) {
+
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, bits)
+
+	case "ecdsa":
+		crv, err := ECDSACurve(curve)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(crv, rand.Reader)
+
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+
+	default:
+		return nil, fmt.Errorf("Unsupported key algorithm: %v", algo)
+
+	}
+
+}
+
+// ECDSACurve resolves the elliptic curve named by an --ecdsa-curve flag.
+func ECDSACurve(curve string) (elliptic.Curve, error) {
+
+	switch strings.ToUpper(curve) {
+	case "P224":
+		return elliptic.P224(), nil
+	case "P256":
+		return elliptic.P256(), nil
+	case "P384":
+		return elliptic.P384(), nil
+	case "P521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("Unsupported ecdsa curve: %v", curve)
+	}
+
+}
+
+// MarshalKey encodes the given signer's private key, using PKCS1 for RSA
+// keys (for backwards compatibility) and PKCS8 for everything else,
+// returning the DER bytes and the PEM block type to write them under.
+func MarshalKey(key crypto.Signer) ([]byte, string, error) {
+
+	switch k := key.(type) {
+
+	case *rsa.PrivateKey:
+		return x509.MarshalPKCS1PrivateKey(k), "RSA PRIVATE KEY", nil
+
+	default:
+		prv, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, "", err
+		}
+		return prv, "PRIVATE KEY", nil
+
+	}
+
+}
+
+// ParseSigner parses a private key stored either as a PKCS1 (RSA-only) or a
+// PKCS8 (RSA, ECDSA, Ed25519) DER block, returning a crypto.Signer.
+func ParseSigner(der []byte) (crypto.Signer, error) {
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Private key does not support signing")
+	}
+
+	return signer, nil
+
+}
+
+// SignatureAlgorithmFor picks a certificate signature algorithm appropriate
+// for the given issuer's public key type. The public key is used rather
+// than the issuer's crypto.Signer because a PKCS#11/HSM-backed signer's
+// concrete Go type reveals nothing about the key it wraps, whereas the
+// certificate's public key is always a concrete *ecdsa.PublicKey,
+// ed25519.PublicKey, or *rsa.PublicKey regardless of where the private key
+// lives.
+func SignatureAlgorithmFor(issuerPub crypto.PublicKey) x509.SignatureAlgorithm {
+
+	switch k := issuerPub.(type) {
+
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P224(), elliptic.P256():
+			return x509.ECDSAWithSHA256
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512
+		default:
+			return x509.ECDSAWithSHA256
+		}
+
+	case ed25519.PublicKey:
+		return x509.PureEd25519
+
+	default:
+		return x509.SHA512WithRSA
+
+	}
+
+}
@@ -0,0 +1,203 @@
+// Copyright © 2016 Abcum Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TemplateSpec describes the fields needed to build an x509 certificate
+// template, shared by every certificate-issuing code path.
+type TemplateSpec struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	NotBefore      time.Time
+	NotAfter       time.Time
+	IsCA           bool
+	KeyUsage       x509.KeyUsage
+	ExtKeyUsage    []x509.ExtKeyUsage
+}
+
+// NewTemplate builds an unsigned certificate template from spec, ready to
+// be passed to x509.CreateCertificate alongside an issuer and signer.
+func NewTemplate(spec TemplateSpec) *x509.Certificate {
+
+	return &x509.Certificate{
+		Subject:               spec.Subject,
+		DNSNames:              spec.DNSNames,
+		IPAddresses:           spec.IPAddresses,
+		EmailAddresses:        spec.EmailAddresses,
+		URIs:                  spec.URIs,
+		BasicConstraintsValid: true,
+		IsCA:                  spec.IsCA,
+		NotBefore:             spec.NotBefore,
+		NotAfter:              spec.NotAfter,
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		KeyUsage:              spec.KeyUsage,
+		ExtKeyUsage:           spec.ExtKeyUsage,
+	}
+
+}
+
+// SplitHosts sorts the given hostnames/addresses into DNS names and IP
+// addresses, as accepted by the `--` positional arguments of `cert cluster`
+// and `cert csr`.
+func SplitHosts(hosts []string) (dns []string, ips []net.IP) {
+
+	for _, v := range hosts {
+		chk := net.ParseIP(v)
+		switch {
+		case chk.To4() != nil:
+			ips = append(ips, chk.To4())
+		case chk.To16() != nil:
+			ips = append(ips, chk.To16())
+		default:
+			dns = append(dns, v)
+		}
+	}
+
+	return
+
+}
+
+// ParseKeyUsage parses a single keyword into an x509.KeyUsage bit.
+func ParseKeyUsage(name string) (x509.KeyUsage, error) {
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "digitalsignature":
+		return x509.KeyUsageDigitalSignature, nil
+	case "contentcommitment":
+		return x509.KeyUsageContentCommitment, nil
+	case "keyencipherment":
+		return x509.KeyUsageKeyEncipherment, nil
+	case "dataencipherment":
+		return x509.KeyUsageDataEncipherment, nil
+	case "keyagreement":
+		return x509.KeyUsageKeyAgreement, nil
+	case "certsign":
+		return x509.KeyUsageCertSign, nil
+	case "crlsign":
+		return x509.KeyUsageCRLSign, nil
+	case "encipheronly":
+		return x509.KeyUsageEncipherOnly, nil
+	case "decipheronly":
+		return x509.KeyUsageDecipherOnly, nil
+	default:
+		return 0, fmt.Errorf("Unknown key usage: %v", name)
+	}
+
+}
+
+// ParseKeyUsages parses a comma-separated list of key usage keywords into a
+// combined x509.KeyUsage bitmask.
+func ParseKeyUsages(names string) (x509.KeyUsage, error) {
+
+	var usage x509.KeyUsage
+
+	if len(strings.TrimSpace(names)) == 0 {
+		return usage, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		u, err := ParseKeyUsage(name)
+		if err != nil {
+			return 0, err
+		}
+		usage |= u
+	}
+
+	return usage, nil
+
+}
+
+// ParseKeyUsageList parses a list of key usage keywords into a combined
+// x509.KeyUsage bitmask, as found in a `keyUsages` YAML array.
+func ParseKeyUsageList(names []string) (x509.KeyUsage, error) {
+
+	var usage x509.KeyUsage
+
+	for _, name := range names {
+		u, err := ParseKeyUsage(name)
+		if err != nil {
+			return 0, err
+		}
+		usage |= u
+	}
+
+	return usage, nil
+
+}
+
+// ParseExtKeyUsage parses a single keyword into an x509.ExtKeyUsage value.
+func ParseExtKeyUsage(name string) (x509.ExtKeyUsage, error) {
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "serverauth":
+		return x509.ExtKeyUsageServerAuth, nil
+	case "clientauth":
+		return x509.ExtKeyUsageClientAuth, nil
+	case "codesigning":
+		return x509.ExtKeyUsageCodeSigning, nil
+	case "emailprotection":
+		return x509.ExtKeyUsageEmailProtection, nil
+	case "timestamping":
+		return x509.ExtKeyUsageTimeStamping, nil
+	case "ocspsigning":
+		return x509.ExtKeyUsageOCSPSigning, nil
+	default:
+		return 0, fmt.Errorf("Unknown extended key usage: %v", name)
+	}
+
+}
+
+// ParseExtKeyUsages parses a comma-separated list of extended key usage
+// keywords, such as "serverAuth,clientAuth".
+func ParseExtKeyUsages(names string) ([]x509.ExtKeyUsage, error) {
+
+	if len(strings.TrimSpace(names)) == 0 {
+		return nil, nil
+	}
+
+	return ParseExtKeyUsageList(strings.Split(names, ","))
+
+}
+
+// ParseExtKeyUsageList parses a list of extended key usage keywords, as
+// found in an `extKeyUsages` YAML array.
+func ParseExtKeyUsageList(names []string) ([]x509.ExtKeyUsage, error) {
+
+	var usages []x509.ExtKeyUsage
+
+	for _, name := range names {
+		u, err := ParseExtKeyUsage(name)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+
+	return usages, nil
+
+}